@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/cloudtools/ssh-cert-authority/util"
+)
+
+// sweepExpiredRequests periodically scans the store for pending (unsigned)
+// requests whose cert ValidBefore has passed, audits and notifies "expired"
+// for each, and deletes them so they aren't reported again next sweep.
+// Requests that already finished signing are left alone; their certs expire
+// on their own schedule and aren't sign_certd's to track.
+func (h *certRequestHandler) sweepExpiredRequests(interval time.Duration) {
+	for range time.Tick(interval) {
+		pending, err := h.Store.List()
+		if err != nil {
+			log.Printf("expiry sweep: unable to list requests: %v\n", err)
+			continue
+		}
+
+		now := uint64(time.Now().Unix())
+		for _, request := range pending {
+			if request.CertSigned {
+				continue
+			}
+			cert, err := certFromStored(request)
+			if err != nil {
+				log.Printf("expiry sweep: unable to parse cert for request %s: %v\n", request.RequestID, err)
+				continue
+			}
+			if cert.ValidBefore == 0 || now < cert.ValidBefore {
+				continue
+			}
+
+			h.audit(ssh_ca_util.AuditEvent{
+				Event: "expired", RequestID: request.RequestID, Environment: request.Environment,
+				Detail: fmt.Sprintf("serial %d expired before collecting enough signatures", request.Serial),
+			})
+			h.Notifiers[request.Environment].Notify(ssh_ca_util.WebhookEvent{
+				Type: "expired", RequestID: request.RequestID, Environment: request.Environment,
+				Principals: cert.ValidPrincipals, ValidAfter: cert.ValidAfter, ValidBefore: cert.ValidBefore,
+				Reason: request.Reason, Signatures: len(request.Signatures), Threshold: h.Config[request.Environment].NumberSignersRequired,
+			})
+			if err := h.Store.Delete(request.RequestID); err != nil {
+				log.Printf("expiry sweep: unable to delete expired request %s: %v\n", request.RequestID, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/cloudtools/ssh-cert-authority/util"
+)
+
+// krlCache holds the most recently built KRL blob so getKRL doesn't shell
+// out to ssh-keygen on every request; it's invalidated whenever a
+// revocation is finalized.
+type krlCache struct {
+	lock sync.Mutex
+	blob []byte
+}
+
+func (c *krlCache) invalidate() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.blob = nil
+}
+
+func (h *certRequestHandler) revokeRequest(rw http.ResponseWriter, req *http.Request) {
+	if err := req.ParseForm(); err != nil {
+		http.Error(rw, fmt.Sprintf("%v", err), http.StatusBadRequest)
+		return
+	}
+
+	revocationID := req.Form.Get("revocationId")
+	if revocationID == "" {
+		config, environment, err := h.formBoilerplate(req)
+		if err != nil {
+			http.Error(rw, fmt.Sprintf("%v", err), http.StatusBadRequest)
+			return
+		}
+		h.createRevocation(rw, req, config, environment)
+		return
+	}
+
+	revocation, ok, err := h.Store.GetRevocation(revocationID)
+	if err != nil {
+		http.Error(rw, fmt.Sprintf("%v", err), http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		http.Error(rw, "Unknown revocation id", http.StatusNotFound)
+		return
+	}
+
+	envConfig, ok := h.Config[revocation.Environment]
+	if !ok {
+		http.Error(rw, "Revocation found to have an invalid env. Weird.", http.StatusBadRequest)
+		return
+	}
+
+	signedCert, err := h.extractCertFromRequest(req, envConfig.AuthorizedSigners)
+	if err != nil {
+		http.Error(rw, fmt.Sprintf("%v", err), http.StatusBadRequest)
+		return
+	}
+	signerFp := ssh_ca_util.MakeFingerprint(signedCert.SignatureKey.Marshal())
+	if err := h.checkOIDCIdentity(req, signerFp); err != nil {
+		http.Error(rw, fmt.Sprintf("%v", err), http.StatusUnauthorized)
+		return
+	}
+
+	// A signer approves a revocation by signing a cert whose KeyId is the
+	// revocation id it's voting for. Checking that here, the same way
+	// signRequest binds a signature to the cert it was made for, stops a
+	// signer's still-valid signature from a previous, unrelated approval
+	// from being replayed against this revocation.
+	if signedCert.KeyId != revocation.RevocationID {
+		log.Printf("Signature for revocation valid, but KeyId %q didn't match revocation %s from %s\n",
+			signedCert.KeyId, revocation.RevocationID, req.RemoteAddr)
+		http.Error(rw, "Signature was valid, but didn't match this revocation.", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.Store.AddRevocationSignature(revocationID, signerFp); err != nil {
+		http.Error(rw, fmt.Sprintf("%v", err), http.StatusInternalServerError)
+		return
+	}
+	log.Printf("Signature for revocation %s received from %s (%s) @ %s\n",
+		revocationID, signerFp, envConfig.AuthorizedSigners[signerFp], req.RemoteAddr)
+
+	updatedRevocation, _, err := h.Store.GetRevocation(revocationID)
+	if err != nil {
+		http.Error(rw, fmt.Sprintf("%v", err), http.StatusInternalServerError)
+		return
+	}
+
+	if len(updatedRevocation.Signatures) >= envConfig.NumberSignersRequired {
+		if err := h.Store.FinalizeRevocation(revocationID, time.Now().Unix()); err != nil {
+			http.Error(rw, fmt.Sprintf("%v", err), http.StatusInternalServerError)
+			return
+		}
+		h.KRLCache.invalidate()
+		log.Printf("Revocation %s finalized after %d signatures\n", revocationID, len(updatedRevocation.Signatures))
+	}
+
+	rw.WriteHeader(http.StatusAccepted)
+	rw.Write([]byte(revocationID))
+}
+
+func (h *certRequestHandler) createRevocation(rw http.ResponseWriter, req *http.Request, config *ssh_ca_util.SignerdConfig, environment string) {
+	requesterCert, err := h.extractCertFromRequest(req, config.AuthorizedSigners)
+	if err != nil {
+		http.Error(rw, fmt.Sprintf("%v", err), http.StatusBadRequest)
+		return
+	}
+	requesterFp := ssh_ca_util.MakeFingerprint(requesterCert.SignatureKey.Marshal())
+	if err := h.checkOIDCIdentity(req, requesterFp); err != nil {
+		http.Error(rw, fmt.Sprintf("%v", err), http.StatusUnauthorized)
+		return
+	}
+
+	serial, err := strconv.ParseUint(req.Form.Get("serial"), 10, 64)
+	if err != nil && req.Form.Get("serial") != "" {
+		http.Error(rw, "serial must be a number", http.StatusBadRequest)
+		return
+	}
+	keyID := req.Form.Get("key_id")
+	if serial == 0 && keyID == "" {
+		http.Error(rw, "Must specify a non-zero serial or a key_id to revoke", http.StatusBadRequest)
+		return
+	}
+	var serialEnd uint64
+	if raw := req.Form.Get("serial_range_end"); raw != "" {
+		serialEnd, err = strconv.ParseUint(raw, 10, 64)
+		if err != nil || serialEnd < serial {
+			http.Error(rw, "serial_range_end must be a number >= serial", http.StatusBadRequest)
+			return
+		}
+	}
+
+	revocationIDBytes := make([]byte, 10)
+	rand.Reader.Read(revocationIDBytes)
+	revocationID := base32.StdEncoding.EncodeToString(revocationIDBytes)
+
+	revocation := ssh_ca_util.PendingRevocation{
+		RevocationID: revocationID,
+		Environment:  environment,
+		Serial:       serial,
+		SerialEnd:    serialEnd,
+		KeyId:        keyID,
+		Reason:       req.Form.Get("reason"),
+		Signatures:   make(map[string]bool),
+	}
+	if err := h.Store.PutRevocation(revocation); err != nil {
+		http.Error(rw, fmt.Sprintf("Revocation not created: %v", err), http.StatusBadRequest)
+		return
+	}
+	log.Printf("Revocation request %s for serial %d-%d key_id %q in env %s received from %s\n",
+		revocationID, serial, serialEnd, keyID, environment, req.RemoteAddr)
+
+	rw.WriteHeader(http.StatusCreated)
+	rw.Write([]byte(revocationID))
+}
+
+func (h *certRequestHandler) getKRL(rw http.ResponseWriter, req *http.Request) {
+	h.KRLCache.lock.Lock()
+	defer h.KRLCache.lock.Unlock()
+
+	if h.KRLCache.blob != nil {
+		rw.Write(h.KRLCache.blob)
+		return
+	}
+
+	revocations, err := h.Store.ListRevocations()
+	if err != nil {
+		http.Error(rw, fmt.Sprintf("%v", err), http.StatusInternalServerError)
+		return
+	}
+
+	signer, err := h.SigningBackend.SignerFor(h.CAFingerprint)
+	if err != nil {
+		http.Error(rw, fmt.Sprintf("Unable to find CA key for KRL: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	blob, err := ssh_ca_util.BuildKRL(revocations, signer.PublicKey())
+	if err != nil {
+		http.Error(rw, fmt.Sprintf("Unable to build KRL: %v", err), http.StatusInternalServerError)
+		return
+	}
+	h.KRLCache.blob = blob
+	rw.Write(blob)
+}
@@ -11,6 +11,7 @@ import (
 	"github.com/cloudtools/ssh-cert-authority/util"
 	"github.com/codegangsta/cli"
 	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"golang.org/x/crypto/ssh"
 	"io"
 	"log"
@@ -21,30 +22,25 @@ import (
 	"time"
 )
 
-type certRequest struct {
-	// This struct tracks state for certificate requests. Imagine this one day
-	// being stored in a persistent data store.
-	request     *ssh.Certificate
-	submitTime  time.Time
-	environment string
-	signatures  map[string]bool
-	certSigned  bool
-	reason      string
-}
-
-func newcertRequest() certRequest {
-	var cr certRequest
-	cr.submitTime = time.Now()
-	cr.certSigned = false
-	cr.signatures = make(map[string]bool)
-	return cr
+type certRequestHandler struct {
+	Config         map[string]ssh_ca_util.SignerdConfig
+	Store          ssh_ca_util.RequestStore
+	AuditSink      ssh_ca_util.AuditSink
+	OIDC           *ssh_ca_util.OIDCAuthenticator
+	Notifiers      map[string]*ssh_ca_util.Notifier
+	SigningBackend ssh_ca_util.SigningKeyBackend
+	CAFingerprint  string
+	KRLCache       krlCache
 }
 
-type certRequestHandler struct {
-	Config       map[string]ssh_ca_util.SignerdConfig
-	state        map[string]certRequest
-	sshAgentConn io.ReadWriter
-	NextSerial   chan uint64
+// certFromStored parses the marshaled cert carried by a PendingRequest back
+// into an *ssh.Certificate for handlers that need to inspect or re-sign it.
+func certFromStored(stored ssh_ca_util.PendingRequest) (*ssh.Certificate, error) {
+	pubKey, err := ssh.ParsePublicKey(stored.Cert)
+	if err != nil {
+		return nil, err
+	}
+	return pubKey.(*ssh.Certificate), nil
 }
 
 type signingRequest struct {
@@ -84,6 +80,12 @@ func (h *certRequestHandler) createSigningRequest(rw http.ResponseWriter, req *h
 		return
 	}
 
+	requesterFp := ssh_ca_util.MakeFingerprint(cert.SignatureKey.Marshal())
+	if err := h.checkOIDCIdentity(req, requesterFp); err != nil {
+		http.Error(rw, fmt.Sprintf("%v", err), http.StatusUnauthorized)
+		return
+	}
+
 	if req.Form["reason"][0] == "" {
 		http.Error(rw, "You forgot to send in a reason", http.StatusBadRequest)
 		return
@@ -93,7 +95,11 @@ func (h *certRequestHandler) createSigningRequest(rw http.ResponseWriter, req *h
 	requestID := make([]byte, 10)
 	rand.Reader.Read(requestID)
 	requestIDStr := base32.StdEncoding.EncodeToString(requestID)
-	nextSerial := <-h.NextSerial
+	nextSerial, err := h.Store.NextSerial()
+	if err != nil {
+		http.Error(rw, fmt.Sprintf("Unable to allocate serial: %v", err), http.StatusInternalServerError)
+		return
+	}
 
 	err = h.saveSigningRequest(config, environment, reason, requestIDStr, nextSerial, cert)
 	if err != nil {
@@ -101,10 +107,23 @@ func (h *certRequestHandler) createSigningRequest(rw http.ResponseWriter, req *h
 		return
 	}
 
-	requesterFp := ssh_ca_util.MakeFingerprint(cert.SignatureKey.Marshal())
-	log.Printf("Cert request serial %d id %s env %s from %s (%s) @ %s principals %v valid from %d to %d for '%s'\n",
-		cert.Serial, requestIDStr, environment, requesterFp, config.AuthorizedUsers[requesterFp],
-		req.RemoteAddr, cert.ValidPrincipals, cert.ValidAfter, cert.ValidBefore, reason)
+	h.audit(ssh_ca_util.AuditEvent{
+		Event:       "request_created",
+		RequestID:   requestIDStr,
+		Environment: environment,
+		Fingerprint: requesterFp,
+		KeyId:       config.AuthorizedUsers[requesterFp],
+		RemoteAddr:  req.RemoteAddr,
+		Reason:      reason,
+		Detail: fmt.Sprintf("serial %d principals %v valid from %d to %d",
+			cert.Serial, cert.ValidPrincipals, cert.ValidAfter, cert.ValidBefore),
+	})
+	h.Notifiers[environment].Notify(ssh_ca_util.WebhookEvent{
+		Type: "created", RequestID: requestIDStr, Environment: environment,
+		Fingerprint: requesterFp, KeyId: config.AuthorizedUsers[requesterFp],
+		Principals: cert.ValidPrincipals, ValidAfter: cert.ValidAfter, ValidBefore: cert.ValidBefore,
+		Reason: reason, Signatures: 0, Threshold: config.NumberSignersRequired,
+	})
 	rw.WriteHeader(http.StatusCreated)
 	rw.Write([]byte(requestIDStr))
 
@@ -127,28 +146,28 @@ func (h *certRequestHandler) saveSigningRequest(config *ssh_ca_util.SignerdConfi
 	}
 	cert.Serial = requestSerial
 
-	certRequest := newcertRequest()
-	certRequest.request = cert
 	if environment == "" {
 		return fmt.Errorf("Environment is a required field")
 	}
-	certRequest.environment = environment
 
 	if reason == "" {
 		return fmt.Errorf("Reason is a required field")
 	}
-	certRequest.reason = reason
 
 	if len(requestIDStr) < 12 {
 		return fmt.Errorf("Request id is too short to be useful.")
 	}
-	_, ok = h.state[requestIDStr]
-	if ok {
-		return fmt.Errorf("Request id '%s' already in use.", requestIDStr)
-	}
-	h.state[requestIDStr] = certRequest
 
-	return nil
+	pendingRequest := ssh_ca_util.PendingRequest{
+		RequestID:   requestIDStr,
+		Environment: environment,
+		Reason:      reason,
+		Serial:      requestSerial,
+		SubmitTime:  time.Now().Unix(),
+		Cert:        cert.Marshal(),
+		Signatures:  make(map[string]bool),
+	}
+	return h.Store.Put(pendingRequest)
 }
 
 func (h *certRequestHandler) extractCertFromRequest(req *http.Request, authorizedSigners map[string]string) (*ssh.Certificate, error) {
@@ -224,21 +243,22 @@ func (h *certRequestHandler) listPendingRequests(rw http.ResponseWriter, req *ht
 
 	foundSomething := false
 	results := make(map[string]listResponseElement)
-	for k, v := range h.state {
-		encodedCert := base64.StdEncoding.EncodeToString(v.request.Marshal())
-		element := newResponseElement(v.environment, v.reason, encodedCert)
-		// Two ways to use this URL. If caller specified a certRequestId
-		// then we return only that one. Otherwise everything.
-		if certRequestID == "" {
-			results[k] = element
+	if certRequestID == "" {
+		pending, err := h.Store.List()
+		if err != nil {
+			http.Error(rw, fmt.Sprintf("Trouble listing requests %v", err), http.StatusInternalServerError)
+			return
+		}
+		for _, v := range pending {
+			results[v.RequestID] = newResponseElement(v.Environment, v.Reason, base64.StdEncoding.EncodeToString(v.Cert))
 			foundSomething = true
-		} else {
-			if certRequestID == k {
-				results[k] = element
-				foundSomething = true
-				break
-			}
 		}
+	} else if v, ok, err := h.Store.Get(certRequestID); err != nil {
+		http.Error(rw, fmt.Sprintf("Trouble fetching request %v", err), http.StatusInternalServerError)
+		return
+	} else if ok {
+		results[v.RequestID] = newResponseElement(v.Environment, v.Reason, base64.StdEncoding.EncodeToString(v.Cert))
+		foundSomething = true
 	}
 	if foundSomething {
 		output, err := json.Marshal(results)
@@ -257,14 +277,20 @@ func (h *certRequestHandler) getRequestStatus(rw http.ResponseWriter, req *http.
 	uriVars := mux.Vars(req)
 	requestID := uriVars["requestID"]
 
-	type Response struct {
-		certSigned bool
-		cert       string
+	stored, ok, err := h.Store.Get(requestID)
+	if err != nil {
+		http.Error(rw, fmt.Sprintf("%v", err), http.StatusInternalServerError)
+		return
 	}
-	if h.state[requestID].certSigned == true {
-		rw.Write([]byte(h.state[requestID].request.Type()))
+	if ok && stored.CertSigned {
+		cert, err := ssh.ParsePublicKey(stored.SignedCert)
+		if err != nil {
+			http.Error(rw, fmt.Sprintf("%v", err), http.StatusInternalServerError)
+			return
+		}
+		rw.Write([]byte(cert.Type()))
 		rw.Write([]byte(" "))
-		rw.Write([]byte(base64.StdEncoding.EncodeToString(h.state[requestID].request.Marshal())))
+		rw.Write([]byte(base64.StdEncoding.EncodeToString(stored.SignedCert)))
 		rw.Write([]byte("\n"))
 	} else {
 		http.Error(rw, "Cert not signed yet.", http.StatusPreconditionFailed)
@@ -274,19 +300,23 @@ func (h *certRequestHandler) getRequestStatus(rw http.ResponseWriter, req *http.
 func (h *certRequestHandler) signRequest(rw http.ResponseWriter, req *http.Request) {
 
 	requestID := mux.Vars(req)["requestID"]
-	originalRequest, ok := h.state[requestID]
+	originalRequest, ok, err := h.Store.Get(requestID)
+	if err != nil {
+		http.Error(rw, fmt.Sprintf("%v", err), http.StatusInternalServerError)
+		return
+	}
 	if !ok {
 		http.Error(rw, "Unknown request id", http.StatusNotFound)
 		return
 	}
 
-	err := req.ParseForm()
+	err = req.ParseForm()
 	if err != nil {
 		http.Error(rw, fmt.Sprintf("%v", err), http.StatusBadRequest)
 		return
 	}
 
-	envConfig, ok := h.Config[originalRequest.environment]
+	envConfig, ok := h.Config[originalRequest.Environment]
 	if !ok {
 		http.Error(rw, "Original request found to have an invalid env. Weird.", http.StatusBadRequest)
 		return
@@ -294,17 +324,28 @@ func (h *certRequestHandler) signRequest(rw http.ResponseWriter, req *http.Reque
 
 	signedCert, err := h.extractCertFromRequest(req, envConfig.AuthorizedSigners)
 	if err != nil {
-		log.Println("Invalid certificate signing request received, ignoring")
+		h.audit(ssh_ca_util.AuditEvent{Event: "rejected", RequestID: requestID, Environment: originalRequest.Environment,
+			RemoteAddr: req.RemoteAddr, Detail: fmt.Sprintf("invalid signing request: %v", err)})
 		http.Error(rw, fmt.Sprintf("%v", err), http.StatusBadRequest)
 		return
 	}
 
 	signerFp := ssh_ca_util.MakeFingerprint(signedCert.SignatureKey.Marshal())
+	if err := h.checkOIDCIdentity(req, signerFp); err != nil {
+		h.audit(ssh_ca_util.AuditEvent{Event: "rejected", RequestID: requestID, Environment: originalRequest.Environment,
+			Fingerprint: signerFp, RemoteAddr: req.RemoteAddr, Detail: fmt.Sprintf("%v", err)})
+		http.Error(rw, fmt.Sprintf("%v", err), http.StatusUnauthorized)
+		return
+	}
 
 	// Verifying that the cert being posted to us here matches the one in the
 	// request. That is, that an attacker isn't using an old signature to sign a
 	// new/different request id
-	requestedCert := h.state[requestID].request
+	requestedCert, err := certFromStored(originalRequest)
+	if err != nil {
+		http.Error(rw, fmt.Sprintf("%v", err), http.StatusInternalServerError)
+		return
+	}
 	signedCert.SignatureKey = requestedCert.SignatureKey
 	signedCert.Signature = nil
 	requestedCert.Signature = nil
@@ -314,30 +355,58 @@ func (h *certRequestHandler) signRequest(rw http.ResponseWriter, req *http.Reque
 	requestedCert.Nonce = []byte("")
 	signedCert.Nonce = []byte("")
 	if !bytes.Equal(requestedCert.Marshal(), signedCert.Marshal()) {
-		log.Println("Signature was valid, but cert didn't match.")
-		log.Printf("Orig req: %#v\n", requestedCert)
-		log.Printf("Sign req: %#v\n", signedCert)
+		h.audit(ssh_ca_util.AuditEvent{Event: "rejected", RequestID: requestID, Environment: originalRequest.Environment,
+			Fingerprint: signerFp, RemoteAddr: req.RemoteAddr, Detail: "signature was valid, but cert didn't match"})
 		http.Error(rw, "Signature was valid, but cert didn't match.", http.StatusBadRequest)
 		return
 	}
 
-	h.state[requestID].signatures[signerFp] = true
-	log.Printf("Signature for serial %d id %s received from %s (%s) @ %s and determined valid\n",
-		signedCert.Serial, requestID, signerFp, envConfig.AuthorizedSigners[signerFp], req.RemoteAddr)
+	if err := h.Store.AddSignature(requestID, signerFp); err != nil {
+		http.Error(rw, fmt.Sprintf("%v", err), http.StatusInternalServerError)
+		return
+	}
+	h.audit(ssh_ca_util.AuditEvent{
+		Event: "signature_received", RequestID: requestID, Environment: originalRequest.Environment,
+		Fingerprint: signerFp, KeyId: envConfig.AuthorizedSigners[signerFp], RemoteAddr: req.RemoteAddr,
+		Detail: fmt.Sprintf("serial %d", signedCert.Serial),
+	})
+
+	updatedRequest, _, err := h.Store.Get(requestID)
+	if err != nil {
+		http.Error(rw, fmt.Sprintf("%v", err), http.StatusInternalServerError)
+		return
+	}
+
+	h.Notifiers[originalRequest.Environment].Notify(ssh_ca_util.WebhookEvent{
+		Type: "signed", RequestID: requestID, Environment: originalRequest.Environment,
+		Fingerprint: signerFp, KeyId: envConfig.AuthorizedSigners[signerFp],
+		Principals: signedCert.ValidPrincipals, ValidAfter: signedCert.ValidAfter, ValidBefore: signedCert.ValidBefore,
+		Signatures: len(updatedRequest.Signatures), Threshold: envConfig.NumberSignersRequired,
+	})
 
-	if len(h.state[requestID].signatures) >= envConfig.NumberSignersRequired {
-		log.Printf("Received %d signatures for %s, signing now.\n", len(h.state[requestID].signatures), requestID)
-		signer, err := ssh_ca_util.GetSignerForFingerprint(envConfig.SigningKeyFingerprint, h.sshAgentConn)
+	if len(updatedRequest.Signatures) >= envConfig.NumberSignersRequired {
+		signer, err := h.SigningBackend.SignerFor(envConfig.SigningKeyFingerprint)
 		if err != nil {
-			log.Printf("Couldn't find signing key for request %s, unable to sign request\n", requestID)
+			h.audit(ssh_ca_util.AuditEvent{Event: "agent_error", RequestID: requestID, Environment: originalRequest.Environment,
+				Detail: fmt.Sprintf("couldn't find signing key: %v", err)})
 			http.Error(rw, "Couldn't find signing key, unable to sign. Sorry.", http.StatusNotFound)
 			return
 		}
-		stateInfo := h.state[requestID]
-		stateInfo.request.SignCert(rand.Reader, signer)
-		stateInfo.certSigned = true
-		// this is weird. see: https://code.google.com/p/go/issues/detail?id=3117
-		h.state[requestID] = stateInfo
+		requestedCert.SignCert(rand.Reader, signer)
+		if err := h.Store.MarkSigned(requestID, requestedCert.Marshal()); err != nil {
+			http.Error(rw, fmt.Sprintf("%v", err), http.StatusInternalServerError)
+			return
+		}
+		h.audit(ssh_ca_util.AuditEvent{
+			Event: "issued", RequestID: requestID, Environment: originalRequest.Environment,
+			Detail: fmt.Sprintf("serial %d, %d signatures", signedCert.Serial, len(updatedRequest.Signatures)),
+		})
+		observeIssuanceLatency(originalRequest.Environment, time.Unix(originalRequest.SubmitTime, 0))
+		h.Notifiers[originalRequest.Environment].Notify(ssh_ca_util.WebhookEvent{
+			Type: "approved", RequestID: requestID, Environment: originalRequest.Environment,
+			Principals: signedCert.ValidPrincipals, ValidAfter: signedCert.ValidAfter, ValidBefore: signedCert.ValidBefore,
+			Signatures: len(updatedRequest.Signatures), Threshold: envConfig.NumberSignersRequired,
+		})
 	}
 
 }
@@ -369,39 +438,137 @@ func signCertd(c *cli.Context) {
 	runSignCertd(config)
 }
 
-func makeCertRequestHandler(config map[string]ssh_ca_util.SignerdConfig) certRequestHandler {
+// sharedConfig picks the StateBackend/StateDSN/AuditSink/AuditDSN settings
+// used process-wide: the request store and audit sink aren't partitioned by
+// environment, so every environment configured must agree on them.
+func sharedConfig(config map[string]ssh_ca_util.SignerdConfig) (ssh_ca_util.SignerdConfig, error) {
+	var chosen ssh_ca_util.SignerdConfig
+	seen := false
+	for environment, envConfig := range config {
+		if !seen {
+			chosen = envConfig
+			seen = true
+			continue
+		}
+		if envConfig.StateBackend != chosen.StateBackend || envConfig.StateDSN != chosen.StateDSN ||
+			envConfig.AuditSink != chosen.AuditSink || envConfig.AuditDSN != chosen.AuditDSN ||
+			envConfig.OIDCIssuer != chosen.OIDCIssuer || envConfig.OIDCAudience != chosen.OIDCAudience ||
+			envConfig.SigningKeyBackend != chosen.SigningKeyBackend {
+			return chosen, fmt.Errorf("environment %s has different StateBackend/AuditSink/OIDC/SigningKeyBackend settings than the rest of the config", environment)
+		}
+	}
+	return chosen, nil
+}
+
+func makeCertRequestHandler(config map[string]ssh_ca_util.SignerdConfig, sshAgentConn io.ReadWriter) (certRequestHandler, error) {
 	var requestHandler certRequestHandler
 	requestHandler.Config = config
-	requestHandler.state = make(map[string]certRequest)
-	requestHandler.NextSerial = make(chan uint64)
-	go func() {
-		var serial uint64
-		for serial = 1; ; serial++ {
-			requestHandler.NextSerial <- serial
-		}
-	}()
-	return requestHandler
+
+	environmentConfig, err := sharedConfig(config)
+	if err != nil {
+		return requestHandler, err
+	}
+	store, err := ssh_ca_util.NewRequestStore(environmentConfig)
+	if err != nil {
+		return requestHandler, fmt.Errorf("unable to create request store: %v", err)
+	}
+	requestHandler.Store = store
+
+	auditSink, err := ssh_ca_util.NewAuditSink(environmentConfig)
+	if err != nil {
+		return requestHandler, fmt.Errorf("unable to create audit sink: %v", err)
+	}
+	requestHandler.AuditSink = auditSink
+
+	oidcAuth, err := ssh_ca_util.NewOIDCAuthenticator(environmentConfig)
+	if err != nil {
+		return requestHandler, fmt.Errorf("unable to create OIDC authenticator: %v", err)
+	}
+	requestHandler.OIDC = oidcAuth
+
+	requestHandler.Notifiers = make(map[string]*ssh_ca_util.Notifier)
+	for environment, envConfig := range config {
+		requestHandler.Notifiers[environment] = ssh_ca_util.NewNotifier(envConfig.Webhooks)
+	}
+
+	requestHandler.CAFingerprint = environmentConfig.SigningKeyFingerprint
+
+	signingBackend, err := ssh_ca_util.NewSigningKeyBackend(environmentConfig.SigningKeyBackend, sshAgentConn)
+	if err != nil {
+		return requestHandler, fmt.Errorf("unable to create signing key backend: %v", err)
+	}
+	requestHandler.SigningBackend = signingBackend
+
+	return requestHandler, nil
+}
+
+// wrapAuth gates a handler behind OIDC bearer-token auth when it's
+// configured, otherwise passes requests straight through unchanged.
+func (h *certRequestHandler) wrapAuth(handler http.HandlerFunc) http.Handler {
+	if h.OIDC == nil {
+		return handler
+	}
+	return h.OIDC.Middleware(handler)
+}
+
+// checkOIDCIdentity requires, when OIDC auth is configured, that the
+// fingerprint wrapAuth's middleware mapped the caller's bearer token to
+// matches the fingerprint of the SSH cert signature already being checked.
+// Without this, a valid token from any mapped user could be paired with an
+// SSH cert signed by a different, unrelated key. It's a no-op when OIDC
+// isn't configured.
+func (h *certRequestHandler) checkOIDCIdentity(req *http.Request, sshFingerprint string) error {
+	if h.OIDC == nil {
+		return nil
+	}
+	oidcFingerprint, ok := ssh_ca_util.FingerprintFromContext(req.Context())
+	if !ok {
+		return fmt.Errorf("no OIDC identity found on request")
+	}
+	if oidcFingerprint != sshFingerprint {
+		return fmt.Errorf("OIDC identity (%s) does not match the cert's signature key (%s)", oidcFingerprint, sshFingerprint)
+	}
+	return nil
 }
 
 func runSignCertd(config map[string]ssh_ca_util.SignerdConfig) {
 	log.Println("Server running version", ssh_ca_util.BuildVersion)
 	log.Println("Server started with config", config)
-	log.Println("Using SSH agent at", os.Getenv("SSH_AUTH_SOCK"))
 
-	sshAgentConn, err := net.Dial("unix", os.Getenv("SSH_AUTH_SOCK"))
+	environmentConfig, err := sharedConfig(config)
 	if err != nil {
-		log.Println("Dial failed:", err)
+		log.Println("Invalid config:", err)
 		os.Exit(1)
 	}
-	requestHandler := makeCertRequestHandler(config)
-	requestHandler.sshAgentConn = sshAgentConn
+
+	var sshAgentConn io.ReadWriter
+	if backend := environmentConfig.SigningKeyBackend.Backend; backend == "" || backend == "agent" {
+		log.Println("Using SSH agent at", os.Getenv("SSH_AUTH_SOCK"))
+		conn, err := net.Dial("unix", os.Getenv("SSH_AUTH_SOCK"))
+		if err != nil {
+			log.Println("Dial failed:", err)
+			os.Exit(1)
+		}
+		sshAgentConn = conn
+	}
+
+	requestHandler, err := makeCertRequestHandler(config, sshAgentConn)
+	if err != nil {
+		log.Println("Unable to set up request handler:", err)
+		os.Exit(1)
+	}
+
+	go requestHandler.sweepExpiredRequests(5 * time.Minute)
 
 	r := mux.NewRouter()
+	r.Path("/metrics").Handler(promhttp.Handler())
 	requests := r.Path("/cert/requests").Subrouter()
-	requests.Methods("POST").HandlerFunc(requestHandler.createSigningRequest)
+	requests.Methods("POST").Handler(requestHandler.wrapAuth(requestHandler.createSigningRequest))
 	requests.Methods("GET").HandlerFunc(requestHandler.listPendingRequests)
 	request := r.Path("/cert/requests/{requestID}").Subrouter()
 	request.Methods("GET").HandlerFunc(requestHandler.getRequestStatus)
-	request.Methods("POST").HandlerFunc(requestHandler.signRequest)
+	request.Methods("POST").Handler(requestHandler.wrapAuth(requestHandler.signRequest))
+	r.Path("/cert/revocations").Methods("POST").Handler(requestHandler.wrapAuth(requestHandler.revokeRequest))
+	r.Path("/cert/krl").Methods("GET").HandlerFunc(requestHandler.getKRL)
 	http.ListenAndServe(":8080", r)
 }
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/cloudtools/ssh-cert-authority/util"
+)
+
+func TestSharedConfigAgrees(t *testing.T) {
+	config := map[string]ssh_ca_util.SignerdConfig{
+		"prod":    {StateBackend: "postgres", StateDSN: "dsn", AuditSink: "file", AuditDSN: "/var/log/x"},
+		"staging": {StateBackend: "postgres", StateDSN: "dsn", AuditSink: "file", AuditDSN: "/var/log/x"},
+	}
+	chosen, err := sharedConfig(config)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if chosen.StateBackend != "postgres" || chosen.StateDSN != "dsn" {
+		t.Fatalf("got %+v, want StateBackend=postgres StateDSN=dsn", chosen)
+	}
+}
+
+func TestSharedConfigDisagrees(t *testing.T) {
+	config := map[string]ssh_ca_util.SignerdConfig{
+		"prod":    {StateBackend: "postgres", StateDSN: "dsn-a"},
+		"staging": {StateBackend: "postgres", StateDSN: "dsn-b"},
+	}
+	if _, err := sharedConfig(config); err == nil {
+		t.Fatal("expected an error when environments disagree on shared settings")
+	}
+}
+
+func TestCheckOIDCIdentityNoopWithoutOIDC(t *testing.T) {
+	h := certRequestHandler{}
+	req, err := http.NewRequest("POST", "/cert/requests", nil)
+	if err != nil {
+		t.Fatalf("unexpected error building request: %v", err)
+	}
+	if err := h.checkOIDCIdentity(req, "some-fingerprint"); err != nil {
+		t.Fatalf("expected no-op when OIDC is unconfigured, got %v", err)
+	}
+}
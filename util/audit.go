@@ -0,0 +1,131 @@
+package ssh_ca_util
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"log/syslog"
+	"net/http"
+	"os"
+	"time"
+)
+
+// AuditEvent is the structured record emitted for every request created,
+// signature received, issuance and rejection so operators can feed a SIEM
+// instead of scraping log.Printf output.
+type AuditEvent struct {
+	Time        time.Time `json:"time"`
+	Event       string    `json:"event"`
+	RequestID   string    `json:"request_id,omitempty"`
+	Environment string    `json:"environment,omitempty"`
+	Fingerprint string    `json:"fingerprint,omitempty"`
+	KeyId       string    `json:"key_id,omitempty"`
+	RemoteAddr  string    `json:"remote_addr,omitempty"`
+	Reason      string    `json:"reason,omitempty"`
+	Detail      string    `json:"detail,omitempty"`
+}
+
+// AuditSink delivers audit events to wherever an operator wants them:
+// a file, syslog, or an HTTP webhook.
+type AuditSink interface {
+	Audit(event AuditEvent) error
+}
+
+// NewAuditSink builds the sink configured for an environment via
+// SignerdConfig's AuditSink/AuditDSN fields. "" and "none" disable auditing.
+func NewAuditSink(config SignerdConfig) (AuditSink, error) {
+	switch config.AuditSink {
+	case "", "none":
+		return nil, nil
+	case "file":
+		return NewFileAuditSink(config.AuditDSN)
+	case "syslog":
+		return NewSyslogAuditSink(config.AuditDSN)
+	case "webhook":
+		return NewWebhookAuditSink(config.AuditDSN), nil
+	default:
+		return nil, fmt.Errorf("unknown AuditSink %q", config.AuditSink)
+	}
+}
+
+type FileAuditSink struct {
+	file *os.File
+}
+
+func NewFileAuditSink(path string) (*FileAuditSink, error) {
+	if path == "" {
+		return nil, fmt.Errorf("AuditDSN must be a file path for the file audit sink")
+	}
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0640)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open audit log %s: %v", path, err)
+	}
+	return &FileAuditSink{file: file}, nil
+}
+
+func (s *FileAuditSink) Audit(event AuditEvent) error {
+	buf, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	buf = append(buf, '\n')
+	_, err = s.file.Write(buf)
+	return err
+}
+
+type SyslogAuditSink struct {
+	writer *syslog.Writer
+}
+
+func NewSyslogAuditSink(raddr string) (*SyslogAuditSink, error) {
+	// syslog.Dial's network arg must be "" only when raddr is also "" (local
+	// unix socket); passing "" with a non-empty raddr silently ignores raddr
+	// and dials the local socket anyway, so a configured remote AuditDSN
+	// needs a real network.
+	network := ""
+	if raddr != "" {
+		network = "udp"
+	}
+	writer, err := syslog.Dial(network, raddr, syslog.LOG_INFO|syslog.LOG_AUTH, "ssh-cert-authority")
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect to syslog: %v", err)
+	}
+	return &SyslogAuditSink{writer: writer}, nil
+}
+
+func (s *SyslogAuditSink) Audit(event AuditEvent) error {
+	buf, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	return s.writer.Info(string(buf))
+}
+
+// WebhookAuditSink POSTs each audit event as JSON to a configured URL.
+// Delivery is best effort: failures are logged, not returned, so a flaky
+// webhook receiver can never block request handling.
+type WebhookAuditSink struct {
+	url    string
+	client *http.Client
+}
+
+func NewWebhookAuditSink(url string) *WebhookAuditSink {
+	return &WebhookAuditSink{url: url, client: &http.Client{Timeout: 5 * time.Second}}
+}
+
+func (s *WebhookAuditSink) Audit(event AuditEvent) error {
+	buf, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	go func() {
+		resp, err := s.client.Post(s.url, "application/json", bytes.NewReader(buf))
+		if err != nil {
+			log.Printf("audit webhook delivery failed: %v\n", err)
+			return
+		}
+		resp.Body.Close()
+	}()
+	return nil
+}
@@ -16,6 +16,34 @@ type SignerdConfig struct {
 	AuthorizedSigners     map[string]string
 	AuthorizedUsers       map[string]string
 	NumberSignersRequired int
+	// StateBackend selects the RequestStore implementation used to persist
+	// pending requests: "memory" (default), "bolt", "sqlite", "postgres" or
+	// "etcd". StateDSN is backend specific: a file path for bolt/sqlite, a
+	// connection string for postgres, or a comma separated endpoint list
+	// for etcd.
+	StateBackend string
+	StateDSN     string
+	// AuditSink selects where structured audit events are delivered: "file",
+	// "syslog" or "webhook" ("" / "none" disables auditing). AuditDSN is
+	// sink specific: a file path, a syslog address, or a webhook URL.
+	AuditSink string
+	AuditDSN  string
+	// OIDC fields enable optional bearer-token authentication in front of
+	// the SSH-cert-signature checks createSigningRequest/signRequest
+	// already do. OIDCIssuer left unset disables OIDC auth entirely.
+	// OIDCClaim names the token claim (default "email") used to look a
+	// caller up in OIDCClaimFingerprints, which maps that claim's value to
+	// the SSH fingerprint it's allowed to act as.
+	OIDCIssuer            string
+	OIDCAudience          string
+	OIDCClaim             string
+	OIDCClaimFingerprints map[string]string
+	// Webhooks are notified asynchronously on request lifecycle events:
+	// creation, each signature, threshold reached, and expiry.
+	Webhooks []WebhookConfig
+	// SigningKeyBackend selects where the CA private key lives: ssh-agent
+	// (the default), a PKCS#11 HSM/YubiHSM, AWS KMS, or GCP KMS.
+	SigningKeyBackend SigningKeyBackendConfig
 }
 
 type SignerConfig struct {
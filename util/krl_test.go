@@ -0,0 +1,36 @@
+package ssh_ca_util
+
+import "testing"
+
+func TestKrlSpecLinesKeyID(t *testing.T) {
+	lines := krlSpecLines([]PendingRevocation{{KeyId: "deadbeef"}})
+	if lines != "id: deadbeef\n" {
+		t.Fatalf("got %q, want %q", lines, "id: deadbeef\n")
+	}
+}
+
+func TestKrlSpecLinesSerial(t *testing.T) {
+	lines := krlSpecLines([]PendingRevocation{{Serial: 42}})
+	if lines != "serial: 42\n" {
+		t.Fatalf("got %q, want %q", lines, "serial: 42\n")
+	}
+}
+
+func TestKrlSpecLinesSerialRange(t *testing.T) {
+	lines := krlSpecLines([]PendingRevocation{{Serial: 10, SerialEnd: 20}})
+	if lines != "serial: 10-20\n" {
+		t.Fatalf("got %q, want %q", lines, "serial: 10-20\n")
+	}
+}
+
+func TestKrlSpecLinesMixed(t *testing.T) {
+	lines := krlSpecLines([]PendingRevocation{
+		{Serial: 1},
+		{KeyId: "abc123"},
+		{Serial: 2, SerialEnd: 5},
+	})
+	want := "serial: 1\nid: abc123\nserial: 2-5\n"
+	if lines != want {
+		t.Fatalf("got %q, want %q", lines, want)
+	}
+}
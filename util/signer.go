@@ -0,0 +1,63 @@
+package ssh_ca_util
+
+import (
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// SigningKeyBackendConfig selects where the CA private key material lives
+// and how to reach it. Backend decides which of the other fields apply:
+// "agent" (the default, current behavior) needs none of them, "pkcs11"
+// needs the PKCS11* fields, "awskms" needs KMSKeyARN, and "gcpkms" needs
+// GCPKeyName.
+type SigningKeyBackendConfig struct {
+	Backend string
+
+	PKCS11Module string
+	PKCS11Slot   uint
+	PKCS11Label  string
+	PKCS11Pin    string
+
+	KMSKeyARN string
+	KMSRegion string
+
+	GCPKeyName string
+}
+
+// SigningKeyBackend produces ssh.Signers for CA fingerprints without the
+// caller needing to know where those keys actually live. This lets the CA
+// private key stay behind an HSM or cloud KMS instead of requiring
+// ssh-agent on the signerd host.
+type SigningKeyBackend interface {
+	SignerFor(fingerprint string) (ssh.Signer, error)
+}
+
+// NewSigningKeyBackend builds the backend configured for a signerd process.
+// sshAgentConn is only used by the (default) agent backend; other backends
+// dial their own key material and ignore it.
+func NewSigningKeyBackend(config SigningKeyBackendConfig, sshAgentConn io.ReadWriter) (SigningKeyBackend, error) {
+	switch config.Backend {
+	case "", "agent":
+		return &AgentSigningKeyBackend{conn: sshAgentConn}, nil
+	case "pkcs11":
+		return NewPKCS11SigningKeyBackend(config)
+	case "awskms":
+		return NewAWSKMSSigningKeyBackend(config)
+	case "gcpkms":
+		return NewGCPKMSSigningKeyBackend(config)
+	default:
+		return nil, fmt.Errorf("unknown SigningKeyBackend %q", config.Backend)
+	}
+}
+
+// AgentSigningKeyBackend is the original signing path: the CA key lives in
+// whatever's listening on SSH_AUTH_SOCK.
+type AgentSigningKeyBackend struct {
+	conn io.ReadWriter
+}
+
+func (b *AgentSigningKeyBackend) SignerFor(fingerprint string) (ssh.Signer, error) {
+	return GetSignerForFingerprint(fingerprint, b.conn)
+}
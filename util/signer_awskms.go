@@ -0,0 +1,82 @@
+package ssh_ca_util
+
+import (
+	"crypto"
+	"crypto/x509"
+	"fmt"
+	"io"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"golang.org/x/crypto/ssh"
+)
+
+// AWSKMSSigningKeyBackend signs with an asymmetric CMK in AWS KMS, so the
+// CA private key never leaves KMS.
+type AWSKMSSigningKeyBackend struct {
+	client      *kms.KMS
+	keyARN      string
+	signer      ssh.Signer
+	fingerprint string
+}
+
+func NewAWSKMSSigningKeyBackend(config SigningKeyBackendConfig) (*AWSKMSSigningKeyBackend, error) {
+	if config.KMSKeyARN == "" {
+		return nil, fmt.Errorf("KMSKeyARN must be set for the awskms backend")
+	}
+	sess, err := session.NewSession(&aws.Config{Region: aws.String(config.KMSRegion)})
+	if err != nil {
+		return nil, fmt.Errorf("unable to create AWS session: %v", err)
+	}
+	client := kms.New(sess)
+
+	pubResp, err := client.GetPublicKey(&kms.GetPublicKeyInput{KeyId: aws.String(config.KMSKeyARN)})
+	if err != nil {
+		return nil, fmt.Errorf("unable to fetch public key for %s: %v", config.KMSKeyARN, err)
+	}
+	pub, err := x509.ParsePKIXPublicKey(pubResp.PublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse KMS public key: %v", err)
+	}
+
+	backend := &AWSKMSSigningKeyBackend{client: client, keyARN: config.KMSKeyARN}
+	cryptoSigner := &awsKMSCryptoSigner{backend: backend, public: pub, signingAlgorithm: aws.StringValue(pubResp.SigningAlgorithms[0])}
+	signer, err := ssh.NewSignerFromSigner(cryptoSigner)
+	if err != nil {
+		return nil, fmt.Errorf("unable to wrap AWS KMS key as an ssh.Signer: %v", err)
+	}
+	backend.signer = signer
+	backend.fingerprint = MakeFingerprint(signer.PublicKey().Marshal())
+	return backend, nil
+}
+
+func (b *AWSKMSSigningKeyBackend) SignerFor(fingerprint string) (ssh.Signer, error) {
+	if fingerprint != b.fingerprint {
+		return nil, fmt.Errorf("AWS KMS backend holds fingerprint %s, not %s", b.fingerprint, fingerprint)
+	}
+	return b.signer, nil
+}
+
+type awsKMSCryptoSigner struct {
+	backend          *AWSKMSSigningKeyBackend
+	public           crypto.PublicKey
+	signingAlgorithm string
+}
+
+func (s *awsKMSCryptoSigner) Public() crypto.PublicKey {
+	return s.public
+}
+
+func (s *awsKMSCryptoSigner) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	resp, err := s.backend.client.Sign(&kms.SignInput{
+		KeyId:            aws.String(s.backend.keyARN),
+		Message:          digest,
+		MessageType:      aws.String(kms.MessageTypeDigest),
+		SigningAlgorithm: aws.String(s.signingAlgorithm),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("AWS KMS Sign failed: %v", err)
+	}
+	return resp.Signature, nil
+}
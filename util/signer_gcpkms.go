@@ -0,0 +1,85 @@
+package ssh_ca_util
+
+import (
+	"context"
+	"crypto"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io"
+
+	kms "cloud.google.com/go/kms/apiv1"
+	"golang.org/x/crypto/ssh"
+	kmspb "google.golang.org/genproto/googleapis/cloud/kms/v1"
+)
+
+// GCPKMSSigningKeyBackend signs with an asymmetric key version in Google
+// Cloud KMS, so the CA private key never leaves KMS.
+type GCPKMSSigningKeyBackend struct {
+	client      *kms.KeyManagementClient
+	keyName     string
+	signer      ssh.Signer
+	fingerprint string
+}
+
+func NewGCPKMSSigningKeyBackend(config SigningKeyBackendConfig) (*GCPKMSSigningKeyBackend, error) {
+	if config.GCPKeyName == "" {
+		return nil, fmt.Errorf("GCPKeyName must be set for the gcpkms backend")
+	}
+	ctx := context.Background()
+	client, err := kms.NewKeyManagementClient(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create GCP KMS client: %v", err)
+	}
+
+	pubResp, err := client.GetPublicKey(ctx, &kmspb.GetPublicKeyRequest{Name: config.GCPKeyName})
+	if err != nil {
+		return nil, fmt.Errorf("unable to fetch public key for %s: %v", config.GCPKeyName, err)
+	}
+	block, _ := pem.Decode([]byte(pubResp.Pem))
+	if block == nil {
+		return nil, fmt.Errorf("unable to PEM decode GCP KMS public key")
+	}
+	pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse GCP KMS public key: %v", err)
+	}
+
+	backend := &GCPKMSSigningKeyBackend{client: client, keyName: config.GCPKeyName}
+	cryptoSigner := &gcpKMSCryptoSigner{backend: backend, public: pub}
+	signer, err := ssh.NewSignerFromSigner(cryptoSigner)
+	if err != nil {
+		return nil, fmt.Errorf("unable to wrap GCP KMS key as an ssh.Signer: %v", err)
+	}
+	backend.signer = signer
+	backend.fingerprint = MakeFingerprint(signer.PublicKey().Marshal())
+	return backend, nil
+}
+
+func (b *GCPKMSSigningKeyBackend) SignerFor(fingerprint string) (ssh.Signer, error) {
+	if fingerprint != b.fingerprint {
+		return nil, fmt.Errorf("GCP KMS backend holds fingerprint %s, not %s", b.fingerprint, fingerprint)
+	}
+	return b.signer, nil
+}
+
+type gcpKMSCryptoSigner struct {
+	backend *GCPKMSSigningKeyBackend
+	public  crypto.PublicKey
+}
+
+func (s *gcpKMSCryptoSigner) Public() crypto.PublicKey {
+	return s.public
+}
+
+func (s *gcpKMSCryptoSigner) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	ctx := context.Background()
+	resp, err := s.backend.client.AsymmetricSign(ctx, &kmspb.AsymmetricSignRequest{
+		Name:   s.backend.keyName,
+		Digest: &kmspb.Digest{Digest: &kmspb.Digest_Sha256{Sha256: digest}},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("GCP KMS AsymmetricSign failed: %v", err)
+	}
+	return resp.Signature, nil
+}
@@ -0,0 +1,89 @@
+package ssh_ca_util
+
+import (
+	"fmt"
+)
+
+// PendingRequest is the persisted representation of a certificate request
+// as it moves from creation through multi-party signing to final issuance.
+// It mirrors the in-memory certRequest struct sign_certd used to keep
+// entirely in a map, but is shaped so it can be marshaled by any of the
+// RequestStore implementations.
+type PendingRequest struct {
+	RequestID   string
+	Environment string
+	Reason      string
+	Serial      uint64
+	SubmitTime  int64 // unix seconds, so it round-trips cleanly through JSON/SQL
+	Cert        []byte
+	Signatures  map[string]bool
+	CertSigned  bool
+	SignedCert  []byte
+}
+
+// PendingRevocation tracks a revocation as it collects the same N-of-M
+// signer approval a signing request does, before being finalized into the
+// permanent revocation list the KRL is built from. SerialEnd is non-zero
+// only for a serial_range (bulk) revocation, in which case [Serial,
+// SerialEnd] are all revoked together. Environment records which
+// environment's AuthorizedSigners/NumberSignersRequired govern this
+// revocation, mirroring PendingRequest.Environment, since a revocation can
+// outlive the POST that created it and later signatures need to be checked
+// against the right config rather than whatever environment happened to be
+// on the request that's adding a signature.
+type PendingRevocation struct {
+	RevocationID string
+	Environment  string
+	Serial       uint64
+	SerialEnd    uint64
+	KeyId        string
+	Principals   []string
+	Reason       string
+	Signatures   map[string]bool
+	Finalized    bool
+	RevokedAt    int64
+}
+
+// RequestStore persists pending certificate requests and their partial
+// signatures so that sign_certd can be restarted (or run as more than one
+// instance) without losing in-flight multi-party signing state. It also
+// persists revocations, since they go through the same N-of-M signer
+// approval and need the same durability.
+type RequestStore interface {
+	// NextSerial returns the next certificate serial number. Implementations
+	// must guarantee the returned value is monotonically increasing and
+	// unique even when multiple signerds share the same store.
+	NextSerial() (uint64, error)
+
+	Put(request PendingRequest) error
+	Get(requestID string) (PendingRequest, bool, error)
+	List() ([]PendingRequest, error)
+	AddSignature(requestID, signerFingerprint string) error
+	MarkSigned(requestID string, signedCert []byte) error
+	Delete(requestID string) error
+
+	PutRevocation(revocation PendingRevocation) error
+	GetRevocation(revocationID string) (PendingRevocation, bool, error)
+	AddRevocationSignature(revocationID, signerFingerprint string) error
+	FinalizeRevocation(revocationID string, revokedAt int64) error
+	ListRevocations() ([]PendingRevocation, error)
+}
+
+// NewRequestStore builds the RequestStore configured for an environment via
+// SignerdConfig.StateBackend. "memory" (or an empty value, for backwards
+// compatibility with configs predating this setting) keeps state in a plain
+// map, matching the original behavior of sign_certd.
+func NewRequestStore(config SignerdConfig) (RequestStore, error) {
+	switch config.StateBackend {
+	case "", "memory":
+		return NewMemoryStore(), nil
+	case "bolt":
+		return NewBoltStore(config.StateDSN)
+	case "sqlite", "postgres":
+		return NewSQLStore(config.StateBackend, config.StateDSN)
+	case "etcd":
+		return NewEtcdStore(config.StateDSN)
+	default:
+		return nil, fmt.Errorf("unknown StateBackend %q", config.StateBackend)
+	}
+}
@@ -0,0 +1,220 @@
+package ssh_ca_util
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+
+	"github.com/boltdb/bolt"
+)
+
+var (
+	boltRequestsBucket    = []byte("requests")
+	boltSerialBucket      = []byte("serial")
+	boltSerialKey         = []byte("next")
+	boltRevocationsBucket = []byte("revocations")
+)
+
+// BoltStore persists requests in a local BoltDB file. It's the recommended
+// backend for a single signerd instance: state survives restarts without
+// requiring an external database.
+type BoltStore struct {
+	db *bolt.DB
+}
+
+func NewBoltStore(dsn string) (*BoltStore, error) {
+	if dsn == "" {
+		return nil, fmt.Errorf("StateDSN must be set to a file path for the bolt backend")
+	}
+	db, err := bolt.Open(dsn, 0600, nil)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open bolt store %s: %v", dsn, err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists(boltRequestsBucket); err != nil {
+			return err
+		}
+		if _, err := tx.CreateBucketIfNotExists(boltRevocationsBucket); err != nil {
+			return err
+		}
+		_, err := tx.CreateBucketIfNotExists(boltSerialBucket)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to initialize bolt store %s: %v", dsn, err)
+	}
+	return &BoltStore{db: db}, nil
+}
+
+func (s *BoltStore) NextSerial() (uint64, error) {
+	var serial uint64
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(boltSerialBucket)
+		serial, _ = binary.Uvarint(bucket.Get(boltSerialKey))
+		serial++
+		buf := make([]byte, binary.MaxVarintLen64)
+		n := binary.PutUvarint(buf, serial)
+		return bucket.Put(boltSerialKey, buf[:n])
+	})
+	return serial, err
+}
+
+func (s *BoltStore) Put(request PendingRequest) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(boltRequestsBucket)
+		if bucket.Get([]byte(request.RequestID)) != nil {
+			return fmt.Errorf("request id '%s' already in use", request.RequestID)
+		}
+		buf, err := json.Marshal(request)
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte(request.RequestID), buf)
+	})
+}
+
+func (s *BoltStore) Get(requestID string) (PendingRequest, bool, error) {
+	var request PendingRequest
+	found := false
+	err := s.db.View(func(tx *bolt.Tx) error {
+		buf := tx.Bucket(boltRequestsBucket).Get([]byte(requestID))
+		if buf == nil {
+			return nil
+		}
+		found = true
+		return json.Unmarshal(buf, &request)
+	})
+	return request, found, err
+}
+
+func (s *BoltStore) List() ([]PendingRequest, error) {
+	var requests []PendingRequest
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltRequestsBucket).ForEach(func(k, v []byte) error {
+			var request PendingRequest
+			if err := json.Unmarshal(v, &request); err != nil {
+				return err
+			}
+			requests = append(requests, request)
+			return nil
+		})
+	})
+	return requests, err
+}
+
+func (s *BoltStore) AddSignature(requestID, signerFingerprint string) error {
+	return s.update(requestID, func(request *PendingRequest) {
+		request.Signatures[signerFingerprint] = true
+	})
+}
+
+func (s *BoltStore) MarkSigned(requestID string, signedCert []byte) error {
+	return s.update(requestID, func(request *PendingRequest) {
+		request.CertSigned = true
+		request.SignedCert = signedCert
+	})
+}
+
+func (s *BoltStore) update(requestID string, mutate func(*PendingRequest)) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(boltRequestsBucket)
+		buf := bucket.Get([]byte(requestID))
+		if buf == nil {
+			return fmt.Errorf("unknown request id '%s'", requestID)
+		}
+		var request PendingRequest
+		if err := json.Unmarshal(buf, &request); err != nil {
+			return err
+		}
+		mutate(&request)
+		newBuf, err := json.Marshal(request)
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte(requestID), newBuf)
+	})
+}
+
+func (s *BoltStore) Delete(requestID string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltRequestsBucket).Delete([]byte(requestID))
+	})
+}
+
+func (s *BoltStore) PutRevocation(revocation PendingRevocation) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(boltRevocationsBucket)
+		if bucket.Get([]byte(revocation.RevocationID)) != nil {
+			return fmt.Errorf("revocation id '%s' already in use", revocation.RevocationID)
+		}
+		buf, err := json.Marshal(revocation)
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte(revocation.RevocationID), buf)
+	})
+}
+
+func (s *BoltStore) GetRevocation(revocationID string) (PendingRevocation, bool, error) {
+	var revocation PendingRevocation
+	found := false
+	err := s.db.View(func(tx *bolt.Tx) error {
+		buf := tx.Bucket(boltRevocationsBucket).Get([]byte(revocationID))
+		if buf == nil {
+			return nil
+		}
+		found = true
+		return json.Unmarshal(buf, &revocation)
+	})
+	return revocation, found, err
+}
+
+func (s *BoltStore) updateRevocation(revocationID string, mutate func(*PendingRevocation)) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(boltRevocationsBucket)
+		buf := bucket.Get([]byte(revocationID))
+		if buf == nil {
+			return fmt.Errorf("unknown revocation id '%s'", revocationID)
+		}
+		var revocation PendingRevocation
+		if err := json.Unmarshal(buf, &revocation); err != nil {
+			return err
+		}
+		mutate(&revocation)
+		newBuf, err := json.Marshal(revocation)
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte(revocationID), newBuf)
+	})
+}
+
+func (s *BoltStore) AddRevocationSignature(revocationID, signerFingerprint string) error {
+	return s.updateRevocation(revocationID, func(revocation *PendingRevocation) {
+		revocation.Signatures[signerFingerprint] = true
+	})
+}
+
+func (s *BoltStore) FinalizeRevocation(revocationID string, revokedAt int64) error {
+	return s.updateRevocation(revocationID, func(revocation *PendingRevocation) {
+		revocation.Finalized = true
+		revocation.RevokedAt = revokedAt
+	})
+}
+
+func (s *BoltStore) ListRevocations() ([]PendingRevocation, error) {
+	var revocations []PendingRevocation
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltRevocationsBucket).ForEach(func(k, v []byte) error {
+			var revocation PendingRevocation
+			if err := json.Unmarshal(v, &revocation); err != nil {
+				return err
+			}
+			if revocation.Finalized {
+				revocations = append(revocations, revocation)
+			}
+			return nil
+		})
+	})
+	return revocations, err
+}
@@ -0,0 +1,319 @@
+package ssh_ca_util
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/coreos/etcd/clientv3"
+)
+
+const (
+	etcdRequestsPrefix    = "/ssh-cert-authority/requests/"
+	etcdRevocationsPrefix = "/ssh-cert-authority/revocations/"
+	etcdSerialKey         = "/ssh-cert-authority/serial"
+	etcdTimeout           = 5 * time.Second
+)
+
+// EtcdStore persists requests in etcd, giving a horizontally scaled group of
+// signerd instances a shared, strongly consistent view of pending requests
+// and a serial counter that stays monotonic across the whole fleet.
+type EtcdStore struct {
+	client *clientv3.Client
+}
+
+func NewEtcdStore(dsn string) (*EtcdStore, error) {
+	if dsn == "" {
+		return nil, fmt.Errorf("StateDSN must be set to a comma separated list of etcd endpoints")
+	}
+	client, err := clientv3.New(clientv3.Config{
+		Endpoints:   strings.Split(dsn, ","),
+		DialTimeout: etcdTimeout,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect to etcd at %s: %v", dsn, err)
+	}
+	return &EtcdStore{client: client}, nil
+}
+
+func (s *EtcdStore) NextSerial() (uint64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), etcdTimeout)
+	defer cancel()
+
+	for {
+		resp, err := s.client.Get(ctx, etcdSerialKey)
+		if err != nil {
+			return 0, err
+		}
+		var serial uint64
+		var modRevision int64
+		if len(resp.Kvs) > 0 {
+			if _, err := fmt.Sscanf(string(resp.Kvs[0].Value), "%d", &serial); err != nil {
+				return 0, err
+			}
+			modRevision = resp.Kvs[0].ModRevision
+		}
+		serial++
+
+		txn := s.client.Txn(ctx).
+			If(clientv3.Compare(clientv3.ModRevision(etcdSerialKey), "=", modRevision)).
+			Then(clientv3.OpPut(etcdSerialKey, fmt.Sprintf("%d", serial)))
+		txnResp, err := txn.Commit()
+		if err != nil {
+			return 0, err
+		}
+		if txnResp.Succeeded {
+			return serial, nil
+		}
+		// Someone else incremented the serial between our Get and our Txn;
+		// retry with the new value.
+	}
+}
+
+func (s *EtcdStore) Put(request PendingRequest) error {
+	ctx, cancel := context.WithTimeout(context.Background(), etcdTimeout)
+	defer cancel()
+
+	key := etcdRequestsPrefix + request.RequestID
+	existing, err := s.client.Get(ctx, key)
+	if err != nil {
+		return err
+	}
+	if len(existing.Kvs) > 0 {
+		return fmt.Errorf("request id '%s' already in use", request.RequestID)
+	}
+	buf, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+	_, err = s.client.Put(ctx, key, string(buf))
+	return err
+}
+
+func (s *EtcdStore) Get(requestID string) (PendingRequest, bool, error) {
+	var request PendingRequest
+	ctx, cancel := context.WithTimeout(context.Background(), etcdTimeout)
+	defer cancel()
+
+	resp, err := s.client.Get(ctx, etcdRequestsPrefix+requestID)
+	if err != nil {
+		return request, false, err
+	}
+	if len(resp.Kvs) == 0 {
+		return request, false, nil
+	}
+	err = json.Unmarshal(resp.Kvs[0].Value, &request)
+	return request, true, err
+}
+
+func (s *EtcdStore) List() ([]PendingRequest, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), etcdTimeout)
+	defer cancel()
+
+	resp, err := s.client.Get(ctx, etcdRequestsPrefix, clientv3.WithPrefix())
+	if err != nil {
+		return nil, err
+	}
+	requests := make([]PendingRequest, 0, len(resp.Kvs))
+	for _, kv := range resp.Kvs {
+		var request PendingRequest
+		if err := json.Unmarshal(kv.Value, &request); err != nil {
+			return nil, err
+		}
+		requests = append(requests, request)
+	}
+	return requests, nil
+}
+
+func (s *EtcdStore) AddSignature(requestID, signerFingerprint string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), etcdTimeout)
+	defer cancel()
+
+	key := etcdRequestsPrefix + requestID
+	for {
+		resp, err := s.client.Get(ctx, key)
+		if err != nil {
+			return err
+		}
+		if len(resp.Kvs) == 0 {
+			return fmt.Errorf("unknown request id '%s'", requestID)
+		}
+		var request PendingRequest
+		if err := json.Unmarshal(resp.Kvs[0].Value, &request); err != nil {
+			return err
+		}
+		request.Signatures[signerFingerprint] = true
+		buf, err := json.Marshal(request)
+		if err != nil {
+			return err
+		}
+
+		txn := s.client.Txn(ctx).
+			If(clientv3.Compare(clientv3.ModRevision(key), "=", resp.Kvs[0].ModRevision)).
+			Then(clientv3.OpPut(key, string(buf)))
+		txnResp, err := txn.Commit()
+		if err != nil {
+			return err
+		}
+		if txnResp.Succeeded {
+			return nil
+		}
+		// Someone else updated this request between our Get and our Txn
+		// (another signer's approval racing ours); retry with the new value.
+	}
+}
+
+func (s *EtcdStore) MarkSigned(requestID string, signedCert []byte) error {
+	request, ok, err := s.Get(requestID)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("unknown request id '%s'", requestID)
+	}
+	request.CertSigned = true
+	request.SignedCert = signedCert
+	return s.put(request)
+}
+
+func (s *EtcdStore) put(request PendingRequest) error {
+	ctx, cancel := context.WithTimeout(context.Background(), etcdTimeout)
+	defer cancel()
+
+	buf, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+	_, err = s.client.Put(ctx, etcdRequestsPrefix+request.RequestID, string(buf))
+	return err
+}
+
+func (s *EtcdStore) Delete(requestID string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), etcdTimeout)
+	defer cancel()
+
+	_, err := s.client.Delete(ctx, etcdRequestsPrefix+requestID)
+	return err
+}
+
+func (s *EtcdStore) PutRevocation(revocation PendingRevocation) error {
+	ctx, cancel := context.WithTimeout(context.Background(), etcdTimeout)
+	defer cancel()
+
+	key := etcdRevocationsPrefix + revocation.RevocationID
+	existing, err := s.client.Get(ctx, key)
+	if err != nil {
+		return err
+	}
+	if len(existing.Kvs) > 0 {
+		return fmt.Errorf("revocation id '%s' already in use", revocation.RevocationID)
+	}
+	buf, err := json.Marshal(revocation)
+	if err != nil {
+		return err
+	}
+	_, err = s.client.Put(ctx, key, string(buf))
+	return err
+}
+
+func (s *EtcdStore) GetRevocation(revocationID string) (PendingRevocation, bool, error) {
+	var revocation PendingRevocation
+	ctx, cancel := context.WithTimeout(context.Background(), etcdTimeout)
+	defer cancel()
+
+	resp, err := s.client.Get(ctx, etcdRevocationsPrefix+revocationID)
+	if err != nil {
+		return revocation, false, err
+	}
+	if len(resp.Kvs) == 0 {
+		return revocation, false, nil
+	}
+	err = json.Unmarshal(resp.Kvs[0].Value, &revocation)
+	return revocation, true, err
+}
+
+func (s *EtcdStore) putRevocation(revocation PendingRevocation) error {
+	ctx, cancel := context.WithTimeout(context.Background(), etcdTimeout)
+	defer cancel()
+
+	buf, err := json.Marshal(revocation)
+	if err != nil {
+		return err
+	}
+	_, err = s.client.Put(ctx, etcdRevocationsPrefix+revocation.RevocationID, string(buf))
+	return err
+}
+
+func (s *EtcdStore) AddRevocationSignature(revocationID, signerFingerprint string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), etcdTimeout)
+	defer cancel()
+
+	key := etcdRevocationsPrefix + revocationID
+	for {
+		resp, err := s.client.Get(ctx, key)
+		if err != nil {
+			return err
+		}
+		if len(resp.Kvs) == 0 {
+			return fmt.Errorf("unknown revocation id '%s'", revocationID)
+		}
+		var revocation PendingRevocation
+		if err := json.Unmarshal(resp.Kvs[0].Value, &revocation); err != nil {
+			return err
+		}
+		revocation.Signatures[signerFingerprint] = true
+		buf, err := json.Marshal(revocation)
+		if err != nil {
+			return err
+		}
+
+		txn := s.client.Txn(ctx).
+			If(clientv3.Compare(clientv3.ModRevision(key), "=", resp.Kvs[0].ModRevision)).
+			Then(clientv3.OpPut(key, string(buf)))
+		txnResp, err := txn.Commit()
+		if err != nil {
+			return err
+		}
+		if txnResp.Succeeded {
+			return nil
+		}
+		// Someone else updated this revocation between our Get and our Txn;
+		// retry with the new value.
+	}
+}
+
+func (s *EtcdStore) FinalizeRevocation(revocationID string, revokedAt int64) error {
+	revocation, ok, err := s.GetRevocation(revocationID)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("unknown revocation id '%s'", revocationID)
+	}
+	revocation.Finalized = true
+	revocation.RevokedAt = revokedAt
+	return s.putRevocation(revocation)
+}
+
+func (s *EtcdStore) ListRevocations() ([]PendingRevocation, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), etcdTimeout)
+	defer cancel()
+
+	resp, err := s.client.Get(ctx, etcdRevocationsPrefix, clientv3.WithPrefix())
+	if err != nil {
+		return nil, err
+	}
+	var revocations []PendingRevocation
+	for _, kv := range resp.Kvs {
+		var revocation PendingRevocation
+		if err := json.Unmarshal(kv.Value, &revocation); err != nil {
+			return nil, err
+		}
+		if revocation.Finalized {
+			revocations = append(revocations, revocation)
+		}
+	}
+	return revocations, nil
+}
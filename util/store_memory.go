@@ -0,0 +1,143 @@
+package ssh_ca_util
+
+import (
+	"fmt"
+	"sync"
+)
+
+// MemoryStore is a RequestStore backed by an in-process map. It is the
+// default backend and reproduces sign_certd's original behavior: fast, but
+// every pending request and partial signature is lost on restart.
+type MemoryStore struct {
+	lock        sync.Mutex
+	state       map[string]PendingRequest
+	revocations map[string]PendingRevocation
+	serial      uint64
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		state:       make(map[string]PendingRequest),
+		revocations: make(map[string]PendingRevocation),
+	}
+}
+
+func (s *MemoryStore) NextSerial() (uint64, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.serial++
+	return s.serial, nil
+}
+
+func (s *MemoryStore) Put(request PendingRequest) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if _, ok := s.state[request.RequestID]; ok {
+		return fmt.Errorf("request id '%s' already in use", request.RequestID)
+	}
+	s.state[request.RequestID] = request
+	return nil
+}
+
+func (s *MemoryStore) Get(requestID string) (PendingRequest, bool, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	request, ok := s.state[requestID]
+	return request, ok, nil
+}
+
+func (s *MemoryStore) List() ([]PendingRequest, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	requests := make([]PendingRequest, 0, len(s.state))
+	for _, request := range s.state {
+		requests = append(requests, request)
+	}
+	return requests, nil
+}
+
+func (s *MemoryStore) AddSignature(requestID, signerFingerprint string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	request, ok := s.state[requestID]
+	if !ok {
+		return fmt.Errorf("unknown request id '%s'", requestID)
+	}
+	request.Signatures[signerFingerprint] = true
+	s.state[requestID] = request
+	return nil
+}
+
+func (s *MemoryStore) MarkSigned(requestID string, signedCert []byte) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	request, ok := s.state[requestID]
+	if !ok {
+		return fmt.Errorf("unknown request id '%s'", requestID)
+	}
+	request.CertSigned = true
+	request.SignedCert = signedCert
+	s.state[requestID] = request
+	return nil
+}
+
+func (s *MemoryStore) Delete(requestID string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.state, requestID)
+	return nil
+}
+
+func (s *MemoryStore) PutRevocation(revocation PendingRevocation) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if _, ok := s.revocations[revocation.RevocationID]; ok {
+		return fmt.Errorf("revocation id '%s' already in use", revocation.RevocationID)
+	}
+	s.revocations[revocation.RevocationID] = revocation
+	return nil
+}
+
+func (s *MemoryStore) GetRevocation(revocationID string) (PendingRevocation, bool, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	revocation, ok := s.revocations[revocationID]
+	return revocation, ok, nil
+}
+
+func (s *MemoryStore) AddRevocationSignature(revocationID, signerFingerprint string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	revocation, ok := s.revocations[revocationID]
+	if !ok {
+		return fmt.Errorf("unknown revocation id '%s'", revocationID)
+	}
+	revocation.Signatures[signerFingerprint] = true
+	s.revocations[revocationID] = revocation
+	return nil
+}
+
+func (s *MemoryStore) FinalizeRevocation(revocationID string, revokedAt int64) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	revocation, ok := s.revocations[revocationID]
+	if !ok {
+		return fmt.Errorf("unknown revocation id '%s'", revocationID)
+	}
+	revocation.Finalized = true
+	revocation.RevokedAt = revokedAt
+	s.revocations[revocationID] = revocation
+	return nil
+}
+
+func (s *MemoryStore) ListRevocations() ([]PendingRevocation, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	revocations := make([]PendingRevocation, 0, len(s.revocations))
+	for _, revocation := range s.revocations {
+		if revocation.Finalized {
+			revocations = append(revocations, revocation)
+		}
+	}
+	return revocations, nil
+}
@@ -0,0 +1,316 @@
+package ssh_ca_util
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// SQLStore persists requests in SQLite (single-node) or Postgres
+// (multi-node, so several signerd instances can share one state table and
+// hand out serials without colliding). Which driver is used is determined
+// by the backend name passed to NewSQLStore, which sign_certd threads
+// straight through from SignerdConfig.StateBackend. driver records which one,
+// so read-modify-write operations know whether they can take a row lock
+// (Postgres supports SELECT ... FOR UPDATE; SQLite's single-writer locking
+// already serializes these for the single-node case SQLite is used for).
+type SQLStore struct {
+	db     *sql.DB
+	driver string
+}
+
+const sqlSchema = `
+CREATE TABLE IF NOT EXISTS requests (
+	request_id TEXT PRIMARY KEY,
+	environment TEXT NOT NULL,
+	reason TEXT NOT NULL,
+	serial BIGINT NOT NULL,
+	submit_time BIGINT NOT NULL,
+	cert BYTEA NOT NULL,
+	signatures TEXT NOT NULL,
+	cert_signed BOOLEAN NOT NULL,
+	signed_cert BYTEA
+);
+CREATE TABLE IF NOT EXISTS serials (
+	id INTEGER PRIMARY KEY CHECK (id = 1),
+	next BIGINT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS revocations (
+	revocation_id TEXT PRIMARY KEY,
+	environment TEXT NOT NULL,
+	serial BIGINT NOT NULL,
+	serial_end BIGINT NOT NULL,
+	key_id TEXT NOT NULL,
+	principals TEXT NOT NULL,
+	reason TEXT NOT NULL,
+	signatures TEXT NOT NULL,
+	finalized BOOLEAN NOT NULL,
+	revoked_at BIGINT NOT NULL
+);
+`
+
+func NewSQLStore(backend, dsn string) (*SQLStore, error) {
+	if dsn == "" {
+		return nil, fmt.Errorf("StateDSN must be set for the %s backend", backend)
+	}
+	driver := map[string]string{"sqlite": "sqlite3", "postgres": "postgres"}[backend]
+	if driver == "" {
+		return nil, fmt.Errorf("SQLStore does not support backend %q", backend)
+	}
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open %s store %s: %v", backend, dsn, err)
+	}
+	if _, err := db.Exec(sqlSchema); err != nil {
+		return nil, fmt.Errorf("unable to initialize %s store schema: %v", backend, err)
+	}
+	if _, err := db.Exec("INSERT INTO serials (id, next) VALUES (1, 0) ON CONFLICT DO NOTHING"); err != nil {
+		// SQLite's ON CONFLICT DO NOTHING needs a unique index target it
+		// already has via the PRIMARY KEY, so this is safe for both drivers;
+		// ignore duplicate-row errors from drivers that don't support the
+		// clause at all.
+		_, _ = db.Exec("INSERT OR IGNORE INTO serials (id, next) VALUES (1, 0)")
+	}
+	return &SQLStore{db: db, driver: driver}, nil
+}
+
+// selectForUpdate appends a row lock to query when the driver supports it.
+// Postgres needs this so two signerds racing a read-modify-write (the next
+// serial, a request's signature map) don't both read the same value before
+// either commits; SQLite serializes writers on its own and doesn't
+// understand SELECT ... FOR UPDATE.
+func (s *SQLStore) selectForUpdate(query string) string {
+	if s.driver == "postgres" {
+		return query + " FOR UPDATE"
+	}
+	return query
+}
+
+func (s *SQLStore) NextSerial() (uint64, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var serial uint64
+	if err := tx.QueryRow(s.selectForUpdate("SELECT next FROM serials WHERE id = 1")).Scan(&serial); err != nil {
+		return 0, err
+	}
+	serial++
+	if _, err := tx.Exec("UPDATE serials SET next = $1 WHERE id = 1", serial); err != nil {
+		return 0, err
+	}
+	return serial, tx.Commit()
+}
+
+func (s *SQLStore) Put(request PendingRequest) error {
+	signatures, err := json.Marshal(request.Signatures)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec(
+		"INSERT INTO requests (request_id, environment, reason, serial, submit_time, cert, signatures, cert_signed) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)",
+		request.RequestID, request.Environment, request.Reason, request.Serial, request.SubmitTime, request.Cert, signatures, request.CertSigned,
+	)
+	return err
+}
+
+func (s *SQLStore) scanRequest(row *sql.Row) (PendingRequest, bool, error) {
+	var request PendingRequest
+	var signatures []byte
+	err := row.Scan(&request.RequestID, &request.Environment, &request.Reason, &request.Serial,
+		&request.SubmitTime, &request.Cert, &signatures, &request.CertSigned, &request.SignedCert)
+	if err == sql.ErrNoRows {
+		return request, false, nil
+	}
+	if err != nil {
+		return request, false, err
+	}
+	if err := json.Unmarshal(signatures, &request.Signatures); err != nil {
+		return request, false, err
+	}
+	return request, true, nil
+}
+
+func (s *SQLStore) Get(requestID string) (PendingRequest, bool, error) {
+	row := s.db.QueryRow(
+		"SELECT request_id, environment, reason, serial, submit_time, cert, signatures, cert_signed, signed_cert FROM requests WHERE request_id = $1",
+		requestID,
+	)
+	return s.scanRequest(row)
+}
+
+func (s *SQLStore) List() ([]PendingRequest, error) {
+	rows, err := s.db.Query("SELECT request_id, environment, reason, serial, submit_time, cert, signatures, cert_signed, signed_cert FROM requests")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var requests []PendingRequest
+	for rows.Next() {
+		var request PendingRequest
+		var signatures []byte
+		if err := rows.Scan(&request.RequestID, &request.Environment, &request.Reason, &request.Serial,
+			&request.SubmitTime, &request.Cert, &signatures, &request.CertSigned, &request.SignedCert); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(signatures, &request.Signatures); err != nil {
+			return nil, err
+		}
+		requests = append(requests, request)
+	}
+	return requests, rows.Err()
+}
+
+func (s *SQLStore) AddSignature(requestID, signerFingerprint string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var raw []byte
+	query := s.selectForUpdate("SELECT signatures FROM requests WHERE request_id = $1")
+	if err := tx.QueryRow(query, requestID).Scan(&raw); err != nil {
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("unknown request id '%s'", requestID)
+		}
+		return err
+	}
+	var signatures map[string]bool
+	if err := json.Unmarshal(raw, &signatures); err != nil {
+		return err
+	}
+	signatures[signerFingerprint] = true
+	buf, err := json.Marshal(signatures)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec("UPDATE requests SET signatures = $1 WHERE request_id = $2", buf, requestID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *SQLStore) MarkSigned(requestID string, signedCert []byte) error {
+	_, err := s.db.Exec("UPDATE requests SET cert_signed = $1, signed_cert = $2 WHERE request_id = $3", true, signedCert, requestID)
+	return err
+}
+
+func (s *SQLStore) Delete(requestID string) error {
+	_, err := s.db.Exec("DELETE FROM requests WHERE request_id = $1", requestID)
+	return err
+}
+
+func (s *SQLStore) PutRevocation(revocation PendingRevocation) error {
+	principals, err := json.Marshal(revocation.Principals)
+	if err != nil {
+		return err
+	}
+	signatures, err := json.Marshal(revocation.Signatures)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec(
+		"INSERT INTO revocations (revocation_id, environment, serial, serial_end, key_id, principals, reason, signatures, finalized, revoked_at) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)",
+		revocation.RevocationID, revocation.Environment, revocation.Serial, revocation.SerialEnd, revocation.KeyId, principals, revocation.Reason, signatures, revocation.Finalized, revocation.RevokedAt,
+	)
+	return err
+}
+
+func (s *SQLStore) scanRevocation(row *sql.Row) (PendingRevocation, bool, error) {
+	var revocation PendingRevocation
+	var principals, signatures []byte
+	err := row.Scan(&revocation.RevocationID, &revocation.Environment, &revocation.Serial, &revocation.SerialEnd, &revocation.KeyId,
+		&principals, &revocation.Reason, &signatures, &revocation.Finalized, &revocation.RevokedAt)
+	if err == sql.ErrNoRows {
+		return revocation, false, nil
+	}
+	if err != nil {
+		return revocation, false, err
+	}
+	if err := json.Unmarshal(principals, &revocation.Principals); err != nil {
+		return revocation, false, err
+	}
+	if err := json.Unmarshal(signatures, &revocation.Signatures); err != nil {
+		return revocation, false, err
+	}
+	return revocation, true, nil
+}
+
+func (s *SQLStore) GetRevocation(revocationID string) (PendingRevocation, bool, error) {
+	row := s.db.QueryRow(
+		"SELECT revocation_id, environment, serial, serial_end, key_id, principals, reason, signatures, finalized, revoked_at FROM revocations WHERE revocation_id = $1",
+		revocationID,
+	)
+	return s.scanRevocation(row)
+}
+
+func (s *SQLStore) AddRevocationSignature(revocationID, signerFingerprint string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var raw []byte
+	query := s.selectForUpdate("SELECT signatures FROM revocations WHERE revocation_id = $1")
+	if err := tx.QueryRow(query, revocationID).Scan(&raw); err != nil {
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("unknown revocation id '%s'", revocationID)
+		}
+		return err
+	}
+	var signatures map[string]bool
+	if err := json.Unmarshal(raw, &signatures); err != nil {
+		return err
+	}
+	signatures[signerFingerprint] = true
+	buf, err := json.Marshal(signatures)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec("UPDATE revocations SET signatures = $1 WHERE revocation_id = $2", buf, revocationID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *SQLStore) FinalizeRevocation(revocationID string, revokedAt int64) error {
+	_, err := s.db.Exec("UPDATE revocations SET finalized = $1, revoked_at = $2 WHERE revocation_id = $3", true, revokedAt, revocationID)
+	return err
+}
+
+func (s *SQLStore) ListRevocations() ([]PendingRevocation, error) {
+	rows, err := s.db.Query(
+		"SELECT revocation_id, environment, serial, serial_end, key_id, principals, reason, signatures, finalized, revoked_at FROM revocations WHERE finalized = $1",
+		true,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var revocations []PendingRevocation
+	for rows.Next() {
+		var revocation PendingRevocation
+		var principals, signatures []byte
+		if err := rows.Scan(&revocation.RevocationID, &revocation.Environment, &revocation.Serial, &revocation.SerialEnd, &revocation.KeyId,
+			&principals, &revocation.Reason, &signatures, &revocation.Finalized, &revocation.RevokedAt); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(principals, &revocation.Principals); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(signatures, &revocation.Signatures); err != nil {
+			return nil, err
+		}
+		revocations = append(revocations, revocation)
+	}
+	return revocations, rows.Err()
+}
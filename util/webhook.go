@@ -0,0 +1,108 @@
+package ssh_ca_util
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// WebhookConfig describes one outbound webhook a SignerdConfig wants
+// notified of request lifecycle events.
+type WebhookConfig struct {
+	URL        string
+	Secret     string
+	MaxRetries int
+}
+
+// WebhookEvent is the signed JSON envelope POSTed to each configured
+// webhook when a request is created, signed, fully approved, or expires.
+type WebhookEvent struct {
+	Type        string    `json:"type"` // "created", "signed", "approved", "expired"
+	Time        time.Time `json:"time"`
+	RequestID   string    `json:"request_id"`
+	Environment string    `json:"environment"`
+	Fingerprint string    `json:"fingerprint"`
+	KeyId       string    `json:"key_id"`
+	Principals  []string  `json:"principals"`
+	ValidAfter  uint64    `json:"valid_after"`
+	ValidBefore uint64    `json:"valid_before"`
+	Reason      string    `json:"reason"`
+	Signatures  int       `json:"signatures"`
+	Threshold   int       `json:"threshold"`
+}
+
+// sign returns the hex HMAC-SHA256 of body using the webhook's secret. The
+// signature is sent in an X-Signature header so receivers can verify the
+// envelope wasn't forged or tampered with in transit.
+func (w WebhookConfig) sign(body []byte) string {
+	mac := hmac.New(sha256.New, []byte(w.Secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Notifier fires WebhookEvents at every configured webhook, asynchronously
+// and with retry/backoff, so a slow or unreachable receiver can never block
+// request handling.
+type Notifier struct {
+	webhooks []WebhookConfig
+	client   *http.Client
+}
+
+func NewNotifier(webhooks []WebhookConfig) *Notifier {
+	return &Notifier{webhooks: webhooks, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+func (n *Notifier) Notify(event WebhookEvent) {
+	if n == nil || len(n.webhooks) == 0 {
+		return
+	}
+	event.Time = time.Now()
+	body, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("unable to marshal webhook event: %v\n", err)
+		return
+	}
+	for _, webhook := range n.webhooks {
+		go n.deliver(webhook, body)
+	}
+}
+
+func (n *Notifier) deliver(webhook WebhookConfig, body []byte) {
+	maxRetries := webhook.MaxRetries
+	if maxRetries <= 0 {
+		maxRetries = 3
+	}
+	backoff := time.Second
+	var lastErr error
+	for attempt := 0; attempt <= maxRetries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+		req, err := http.NewRequest("POST", webhook.URL, bytes.NewReader(body))
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Signature", webhook.sign(body))
+
+		resp, err := n.client.Do(req)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+			return
+		}
+		lastErr = fmt.Errorf("webhook %s returned status %d", webhook.URL, resp.StatusCode)
+	}
+	log.Printf("giving up delivering webhook to %s after %d attempts: %v\n", webhook.URL, maxRetries+1, lastErr)
+}
@@ -0,0 +1,30 @@
+package ssh_ca_util
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+)
+
+func TestWebhookConfigSign(t *testing.T) {
+	w := WebhookConfig{Secret: "shh"}
+	body := []byte(`{"type":"created"}`)
+
+	mac := hmac.New(sha256.New, []byte(w.Secret))
+	mac.Write(body)
+	want := hex.EncodeToString(mac.Sum(nil))
+
+	if got := w.sign(body); got != want {
+		t.Fatalf("sign() = %q, want %q", got, want)
+	}
+}
+
+func TestWebhookConfigSignDiffersBySecret(t *testing.T) {
+	body := []byte(`{"type":"created"}`)
+	a := WebhookConfig{Secret: "one"}.sign(body)
+	b := WebhookConfig{Secret: "two"}.sign(body)
+	if a == b {
+		t.Fatalf("signatures with different secrets should differ, both were %q", a)
+	}
+}